@@ -0,0 +1,37 @@
+// Package playlist builds an in-memory m3u8.MediaPlaylist from segments
+// produced by internal/segment, so a freshly segmented input looks to the
+// rest of the faker exactly like one loaded from a pre-existing m3u8 file.
+package playlist
+
+import (
+	"fmt"
+
+	"github.com/chadnickbok/lhls_test/internal/segment"
+	"github.com/grafov/m3u8"
+)
+
+// Build lays out segments, in order, as a static m3u8.MediaPlaylist whose
+// EXT-X-TARGETDURATION is targetDuration, or the longest segment's duration
+// rounded up when that is larger.
+func Build(segments []segment.Segment, targetDuration float64) (*m3u8.MediaPlaylist, error) {
+	capacity := uint(len(segments))
+	if capacity == 0 {
+		capacity = 1
+	}
+
+	mediapl, err := m3u8.NewMediaPlaylist(capacity, capacity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create playlist: %w", err)
+	}
+
+	for _, s := range segments {
+		if err := mediapl.Append(s.URI, s.Duration, ""); err != nil {
+			return nil, fmt.Errorf("failed to append segment %s: %w", s.URI, err)
+		}
+	}
+	if mediapl.TargetDuration < targetDuration {
+		mediapl.TargetDuration = targetDuration
+	}
+
+	return mediapl, nil
+}
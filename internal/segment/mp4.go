@@ -0,0 +1,216 @@
+package segment
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SegmentMP4 reads a fragmented MP4 (CMAF) input from r and writes each
+// moof/mdat fragment pair out as its own segment under outDir, named
+// "segment-N.m4s". Any leading boxes (ftyp/moov) are written separately to
+// outDir/init.mp4, since every fragment needs it as an initialization
+// segment. Fragments in a CMAF stream are already cut on keyframes by
+// whatever produced them, so unlike SegmentTS there's no target duration to
+// aim for here; each fragment becomes one segment, with its duration read
+// from the fragment's own trun/tfhd boxes.
+func SegmentMP4(r io.Reader, outDir string) ([]Segment, error) {
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create segment dir: %w", err)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mp4 input: %w", err)
+	}
+
+	timescale := uint32(90000)
+	var initSegment []byte
+	var segments []Segment
+	var pendingMoof []byte
+	segIndex := 0
+	seenFragment := false
+
+	offset := 0
+	for offset+8 <= len(data) {
+		size := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		boxType := string(data[offset+4 : offset+8])
+		if size < 8 || offset+size > len(data) {
+			break
+		}
+		box := data[offset : offset+size]
+
+		switch boxType {
+		case "moof":
+			seenFragment = true
+			pendingMoof = box
+		case "mdat":
+			if pendingMoof != nil {
+				name := fmt.Sprintf("segment-%d.m4s", segIndex)
+				fragment := append(append([]byte{}, pendingMoof...), box...)
+				if err := os.WriteFile(filepath.Join(outDir, name), fragment, 0o644); err != nil {
+					return nil, fmt.Errorf("failed to write %s: %w", name, err)
+				}
+				segments = append(segments, Segment{URI: name, Duration: fragmentDuration(pendingMoof, timescale)})
+				segIndex++
+				pendingMoof = nil
+			}
+		case "moov":
+			if ts := findTimescale(box); ts > 0 {
+				timescale = ts
+			}
+			initSegment = append(initSegment, box...)
+		default:
+			// Boxes like styp/sidx/prft commonly precede every fragment in
+			// a CMAF stream, not just the leading ftyp/moov run, so once
+			// the first fragment has been seen they are kept out of the
+			// init segment; they are not written into any segment file
+			// either.
+			if !seenFragment {
+				initSegment = append(initSegment, box...)
+			}
+		}
+
+		offset += size
+	}
+
+	if len(initSegment) > 0 {
+		if err := os.WriteFile(filepath.Join(outDir, "init.mp4"), initSegment, 0o644); err != nil {
+			return nil, fmt.Errorf("failed to write init segment: %w", err)
+		}
+	}
+
+	return segments, nil
+}
+
+// findBoxes returns the top-level boxes of the given type within data,
+// without descending into their children.
+func findBoxes(data []byte, boxType string) [][]byte {
+	var boxes [][]byte
+	offset := 0
+	for offset+8 <= len(data) {
+		size := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		curType := string(data[offset+4 : offset+8])
+		if size < 8 || offset+size > len(data) {
+			break
+		}
+		if curType == boxType {
+			boxes = append(boxes, data[offset+8:offset+size])
+		}
+		offset += size
+	}
+	return boxes
+}
+
+// findTimescale digs through moov/trak/mdia/mdhd for the media timescale.
+func findTimescale(moov []byte) uint32 {
+	for _, trak := range findBoxes(moov, "trak") {
+		for _, mdia := range findBoxes(trak, "mdia") {
+			for _, mdhd := range findBoxes(mdia, "mdhd") {
+				if len(mdhd) < 4 {
+					continue
+				}
+				version := mdhd[0]
+				if version == 1 && len(mdhd) >= 4+16+4 {
+					return binary.BigEndian.Uint32(mdhd[4+16 : 4+16+4])
+				}
+				if version == 0 && len(mdhd) >= 4+8+4 {
+					return binary.BigEndian.Uint32(mdhd[4+8 : 4+8+4])
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// fragmentDuration sums the sample durations in moof's trun box(es), falling
+// back to tfhd's default_sample_duration when a trun doesn't carry explicit
+// per-sample durations.
+func fragmentDuration(moof []byte, timescale uint32) float64 {
+	var totalTicks int64
+	for _, traf := range findBoxes(moof, "traf") {
+		defaultDuration := uint32(0)
+		for _, tfhd := range findBoxes(traf, "tfhd") {
+			if d, ok := tfhdDefaultDuration(tfhd); ok {
+				defaultDuration = d
+			}
+		}
+		for _, trun := range findBoxes(traf, "trun") {
+			totalTicks += trunDuration(trun, defaultDuration)
+		}
+	}
+	if totalTicks == 0 || timescale == 0 {
+		return 0
+	}
+	return float64(totalTicks) / float64(timescale)
+}
+
+// tfhdDefaultDuration reads default_sample_duration from a tfhd box, if the
+// corresponding flag is set.
+func tfhdDefaultDuration(tfhd []byte) (uint32, bool) {
+	if len(tfhd) < 8 {
+		return 0, false
+	}
+	flags := uint32(tfhd[1])<<16 | uint32(tfhd[2])<<8 | uint32(tfhd[3])
+	offset := 8 // version(1) + flags(3) + track_ID(4)
+	if flags&0x000001 != 0 {
+		offset += 8 // base_data_offset
+	}
+	if flags&0x000002 != 0 {
+		offset += 4 // sample_description_index
+	}
+	const defaultSampleDurationFlag = 0x000008
+	if flags&defaultSampleDurationFlag == 0 || offset+4 > len(tfhd) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(tfhd[offset : offset+4]), true
+}
+
+// trunDuration returns the total duration, in timescale ticks, of the
+// samples described by a trun box.
+func trunDuration(trun []byte, defaultDuration uint32) int64 {
+	if len(trun) < 8 {
+		return 0
+	}
+	flags := uint32(trun[1])<<16 | uint32(trun[2])<<8 | uint32(trun[3])
+	sampleCount := binary.BigEndian.Uint32(trun[4:8])
+
+	offset := 8
+	const (
+		dataOffsetPresent      = 0x000001
+		firstSampleFlagPresent = 0x000004
+		sampleDurationPresent  = 0x000100
+		sampleSizePresent      = 0x000200
+		sampleFlagsPresent     = 0x000400
+		sampleCompositionTime  = 0x000800
+	)
+	if flags&dataOffsetPresent != 0 {
+		offset += 4
+	}
+	if flags&firstSampleFlagPresent != 0 {
+		offset += 4
+	}
+
+	if flags&sampleDurationPresent == 0 {
+		return int64(defaultDuration) * int64(sampleCount)
+	}
+
+	entrySize := 0
+	for _, present := range []bool{true, flags&sampleSizePresent != 0, flags&sampleFlagsPresent != 0, flags&sampleCompositionTime != 0} {
+		if present {
+			entrySize += 4
+		}
+	}
+
+	var total int64
+	for i := uint32(0); i < sampleCount; i++ {
+		if offset+4 > len(trun) {
+			break
+		}
+		total += int64(binary.BigEndian.Uint32(trun[offset : offset+4]))
+		offset += entrySize
+	}
+	return total
+}
@@ -0,0 +1,270 @@
+package segment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// box builds a top-level MP4 box (size + type + payload), matching the
+// layout findBoxes/SegmentMP4 scan for.
+func box(boxType string, payload []byte) []byte {
+	buf := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(8+len(payload)))
+	copy(buf[4:8], boxType)
+	copy(buf[8:], payload)
+	return buf
+}
+
+func u32(v uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, v)
+	return buf
+}
+
+func TestFindBoxes(t *testing.T) {
+	data := append(append(box("styp", []byte("a")), box("moof", []byte("bb"))...), box("styp", []byte("c"))...)
+
+	styps := findBoxes(data, "styp")
+	if len(styps) != 2 {
+		t.Fatalf("got %d styp boxes, want 2", len(styps))
+	}
+	if string(styps[0]) != "a" || string(styps[1]) != "c" {
+		t.Errorf("unexpected styp payloads: %q, %q", styps[0], styps[1])
+	}
+
+	moofs := findBoxes(data, "moof")
+	if len(moofs) != 1 || string(moofs[0]) != "bb" {
+		t.Errorf("unexpected moof boxes: %v", moofs)
+	}
+}
+
+func TestFindBoxesTruncated(t *testing.T) {
+	// A trailing partial box (fewer than 8 bytes) must not panic or be
+	// mistaken for a valid one.
+	data := append(box("styp", nil), 0x00, 0x00, 0x00)
+	if boxes := findBoxes(data, "styp"); len(boxes) != 1 {
+		t.Fatalf("got %d styp boxes, want 1", len(boxes))
+	}
+}
+
+func mdhd(version byte, timescale uint32) []byte {
+	if version == 1 {
+		payload := make([]byte, 4+16+4)
+		payload[0] = 1
+		binary.BigEndian.PutUint32(payload[4+16:4+16+4], timescale)
+		return payload
+	}
+	payload := make([]byte, 4+8+4)
+	binary.BigEndian.PutUint32(payload[4+8:4+8+4], timescale)
+	return payload
+}
+
+func TestFindTimescale(t *testing.T) {
+	tests := []struct {
+		name    string
+		version byte
+		want    uint32
+	}{
+		{"version0", 0, 90000},
+		{"version1", 1, 48000},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			moov := box("trak", box("mdia", box("mdhd", mdhd(tc.version, tc.want))))
+			if got := findTimescale(moov); got != tc.want {
+				t.Errorf("findTimescale() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFindTimescaleMissing(t *testing.T) {
+	if got := findTimescale(box("trak", box("mdia", nil))); got != 0 {
+		t.Errorf("findTimescale() = %d, want 0", got)
+	}
+}
+
+func tfhd(flags uint32, defaultDuration uint32) []byte {
+	payload := make([]byte, 0, 20)
+	payload = append(payload, 0) // version
+	payload = append(payload, byte(flags>>16), byte(flags>>8), byte(flags))
+	payload = append(payload, u32(0)...) // track_ID
+	if flags&0x000001 != 0 {
+		payload = append(payload, make([]byte, 8)...) // base_data_offset
+	}
+	if flags&0x000002 != 0 {
+		payload = append(payload, u32(0)...) // sample_description_index
+	}
+	if flags&0x000008 != 0 {
+		payload = append(payload, u32(defaultDuration)...)
+	}
+	return payload
+}
+
+func TestTfhdDefaultDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		flags    uint32
+		duration uint32
+		wantOK   bool
+	}{
+		{"flag unset", 0x000000, 3000, false},
+		{"flag set", 0x000008, 3000, true},
+		{"flag set with base_data_offset", 0x000001 | 0x000008, 4500, true},
+		{"flag set with sample_description_index", 0x000002 | 0x000008, 9000, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := tfhdDefaultDuration(tfhd(tc.flags, tc.duration))
+			if ok != tc.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tc.wantOK)
+			}
+			if ok && got != tc.duration {
+				t.Errorf("default duration = %d, want %d", got, tc.duration)
+			}
+		})
+	}
+}
+
+func TestTfhdDefaultDurationTooShort(t *testing.T) {
+	if _, ok := tfhdDefaultDuration([]byte{0, 0, 0}); ok {
+		t.Error("expected ok=false for a tfhd shorter than the flags field")
+	}
+}
+
+// trun builds a trun box payload with the given flags and per-sample
+// durations (and, when present, a 4-byte zero filler for every other configured
+// field, to match the real entry layout's stride).
+func trun(flags uint32, durations []uint32) []byte {
+	payload := make([]byte, 0)
+	payload = append(payload, 0)
+	payload = append(payload, byte(flags>>16), byte(flags>>8), byte(flags))
+	payload = append(payload, u32(uint32(len(durations)))...)
+	if flags&0x000001 != 0 {
+		payload = append(payload, u32(0)...) // data_offset
+	}
+	if flags&0x000004 != 0 {
+		payload = append(payload, u32(0)...) // first_sample_flags
+	}
+	for _, d := range durations {
+		if flags&0x000100 != 0 {
+			payload = append(payload, u32(d)...)
+		}
+		if flags&0x000200 != 0 {
+			payload = append(payload, u32(0)...) // sample_size
+		}
+		if flags&0x000400 != 0 {
+			payload = append(payload, u32(0)...) // sample_flags
+		}
+		if flags&0x000800 != 0 {
+			payload = append(payload, u32(0)...) // sample_composition_time_offset
+		}
+	}
+	return payload
+}
+
+func TestTrunDuration(t *testing.T) {
+	t.Run("no explicit durations falls back to default", func(t *testing.T) {
+		got := trunDuration(trun(0x000000, []uint32{0, 0, 0}), 1000)
+		if want := int64(3000); got != want {
+			t.Errorf("trunDuration() = %d, want %d", got, want)
+		}
+	})
+
+	t.Run("explicit durations", func(t *testing.T) {
+		got := trunDuration(trun(0x000100, []uint32{1000, 2000, 1500}), 0)
+		if want := int64(4500); got != want {
+			t.Errorf("trunDuration() = %d, want %d", got, want)
+		}
+	})
+
+	t.Run("explicit durations with size and flags interleaved", func(t *testing.T) {
+		flags := uint32(0x000100 | 0x000200 | 0x000400)
+		got := trunDuration(trun(flags, []uint32{1000, 2000}), 0)
+		if want := int64(3000); got != want {
+			t.Errorf("trunDuration() = %d, want %d", got, want)
+		}
+	})
+
+	t.Run("truncated entries stop early instead of panicking", func(t *testing.T) {
+		full := trun(0x000100, []uint32{1000, 2000, 1500})
+		got := trunDuration(full[:len(full)-2], 0)
+		if want := int64(3000); got != want {
+			t.Errorf("trunDuration() = %d, want %d", got, want)
+		}
+	})
+}
+
+func TestFragmentDuration(t *testing.T) {
+	traf := box("tfhd", tfhd(0x000008, 1000)) // default duration, unused since trun has explicit durations
+	traf = append(traf, box("trun", trun(0x000100, []uint32{1000, 2000}))...)
+	moof := box("traf", traf)
+
+	const timescale = 1000
+	got := fragmentDuration(moof, timescale)
+	if want := 3.0; got != want {
+		t.Errorf("fragmentDuration() = %v, want %v", got, want)
+	}
+}
+
+func TestFragmentDurationFallsBackToTfhdDefault(t *testing.T) {
+	traf := box("tfhd", tfhd(0x000008, 500))
+	traf = append(traf, box("trun", trun(0x000000, []uint32{0, 0}))...)
+	moof := box("traf", traf)
+
+	got := fragmentDuration(moof, 1000)
+	if want := 1.0; got != want { // 2 samples * 500 ticks / 1000 timescale
+		t.Errorf("fragmentDuration() = %v, want %v", got, want)
+	}
+}
+
+func TestFragmentDurationZeroTimescale(t *testing.T) {
+	traf := box("tfhd", tfhd(0x000008, 500))
+	traf = append(traf, box("trun", trun(0x000000, []uint32{0}))...)
+	moof := box("traf", traf)
+
+	if got := fragmentDuration(moof, 0); got != 0 {
+		t.Errorf("fragmentDuration() = %v, want 0", got)
+	}
+}
+
+// TestSegmentMP4InterFragmentBoxes is a regression test for a bug where a
+// styp/sidx/prft box preceding a non-first fragment was appended to
+// init.mp4 instead of being left alone, because the scan only checked
+// whether a moof was currently pending rather than whether any fragment
+// had been seen yet.
+func TestSegmentMP4InterFragmentBoxes(t *testing.T) {
+	moov := box("moov", box("trak", box("mdia", box("mdhd", mdhd(0, 90000)))))
+	traf1 := append(box("tfhd", tfhd(0x000008, 9000)), box("trun", trun(0x000000, []uint32{0}))...)
+	traf2 := append(box("tfhd", tfhd(0x000008, 9000)), box("trun", trun(0x000000, []uint32{0}))...)
+
+	var data []byte
+	data = append(data, box("ftyp", []byte("isom"))...)
+	data = append(data, moov...)
+	data = append(data, box("styp", []byte("leading"))...)
+	data = append(data, box("moof", box("traf", traf1))...)
+	data = append(data, box("mdat", []byte("frame1"))...)
+	data = append(data, box("styp", []byte("between"))...)
+	data = append(data, box("moof", box("traf", traf2))...)
+	data = append(data, box("mdat", []byte("frame2"))...)
+
+	outDir := t.TempDir()
+	segments, err := SegmentMP4(bytes.NewReader(data), outDir)
+	if err != nil {
+		t.Fatalf("SegmentMP4() error = %v", err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("got %d segments, want 2", len(segments))
+	}
+
+	init, err := os.ReadFile(filepath.Join(outDir, "init.mp4"))
+	if err != nil {
+		t.Fatalf("failed to read init.mp4: %v", err)
+	}
+	if got := len(findBoxes(init, "styp")); got != 1 {
+		t.Errorf("init.mp4 contains %d styp boxes, want 1 (the leading one only)", got)
+	}
+}
@@ -0,0 +1,12 @@
+// Package segment demuxes an arbitrary MPEG-TS or fragmented MP4 input into
+// keyframe-aligned segments, the way a real HLS packager would, so the
+// faker can stand up an LHLS endpoint straight from raw source media
+// instead of requiring a pre-segmented m3u8 and .ts files on disk.
+package segment
+
+// Segment is one cut of the source media, already written to disk under the
+// output directory passed to SegmentTS/SegmentMP4.
+type Segment struct {
+	URI      string
+	Duration float64
+}
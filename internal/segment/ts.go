@@ -0,0 +1,227 @@
+package segment
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+)
+
+// SegmentTS reads a raw MPEG-TS stream from r and cuts it into segments of
+// roughly targetDuration seconds, writing each to outDir as "segment-N.ts".
+// Cuts land on a keyframe: it follows the PAT to the PMT, the PMT to the
+// video elementary stream's PID, and then watches that PID's PES headers
+// for PTS values and the adaptation field's random_access_indicator, the
+// same signal ffmpeg's own TS segmenter uses to find keyframe boundaries.
+// If the stream never sets random_access_indicator (some non-ffmpeg muxers
+// omit it), cuts fall back to duration alone at the next PES header so the
+// whole input doesn't collapse into a single segment.
+func SegmentTS(r io.Reader, targetDuration float64, outDir string) ([]Segment, error) {
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create segment dir: %w", err)
+	}
+
+	pmtPID := -1
+	videoPID := -1
+	everSawRandomAccess := false
+
+	var segments []Segment
+	var buf bytes.Buffer
+	segIndex := 0
+	segStartPTS := int64(-1)
+	lastPTS := int64(-1)
+
+	flush := func(duration float64) error {
+		if buf.Len() == 0 {
+			return nil
+		}
+		name := fmt.Sprintf("segment-%d.ts", segIndex)
+		if err := os.WriteFile(filepath.Join(outDir, name), buf.Bytes(), 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		segments = append(segments, Segment{URI: name, Duration: duration})
+		segIndex++
+		buf.Reset()
+		return nil
+	}
+
+	packet := make([]byte, tsPacketSize)
+	for {
+		if _, err := io.ReadFull(r, packet); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to read TS packet: %w", err)
+		}
+		if packet[0] != tsSyncByte {
+			return nil, fmt.Errorf("lost TS sync: expected 0x47, got 0x%02x", packet[0])
+		}
+
+		pid := (int(packet[1]&0x1f) << 8) | int(packet[2])
+		pusi := packet[1]&0x40 != 0
+		adaptationFieldControl := (packet[3] >> 4) & 0x3
+		hasAdaptation := adaptationFieldControl == 2 || adaptationFieldControl == 3
+		hasPayload := adaptationFieldControl == 1 || adaptationFieldControl == 3
+
+		payloadOffset := 4
+		randomAccess := false
+		if hasAdaptation && payloadOffset < tsPacketSize {
+			adaptationLength := int(packet[4])
+			if adaptationLength > 0 && payloadOffset+1 < tsPacketSize {
+				randomAccess = packet[5]&0x40 != 0
+			}
+			payloadOffset += 1 + adaptationLength
+		}
+		if payloadOffset > tsPacketSize {
+			payloadOffset = tsPacketSize
+		}
+		if randomAccess {
+			everSawRandomAccess = true
+		}
+
+		switch {
+		case pid == 0 && pusi:
+			if pmtPID < 0 {
+				pmtPID = parsePAT(packet[payloadOffset:])
+			}
+		case pid == pmtPID && pusi:
+			if videoPID < 0 {
+				videoPID = parsePMT(packet[payloadOffset:])
+			}
+		case pid == videoPID && pusi && hasPayload:
+			if pts, ok := parsePESPTS(packet[payloadOffset:]); ok {
+				if segStartPTS < 0 {
+					segStartPTS = pts
+				} else if randomAccess || !everSawRandomAccess {
+					if duration := ptsDelta(pts, segStartPTS); duration >= targetDuration {
+						if err := flush(duration); err != nil {
+							return nil, err
+						}
+						segStartPTS = pts
+					}
+				}
+				lastPTS = pts
+			}
+		}
+
+		buf.Write(packet)
+	}
+
+	finalDuration := targetDuration
+	if segStartPTS >= 0 && lastPTS > segStartPTS {
+		finalDuration = ptsDelta(lastPTS, segStartPTS)
+	}
+	if err := flush(finalDuration); err != nil {
+		return nil, err
+	}
+
+	return segments, nil
+}
+
+// ptsDelta returns the time in seconds between two 90kHz PTS values,
+// accounting for the 33-bit PTS clock wrapping around.
+func ptsDelta(pts, start int64) float64 {
+	delta := pts - start
+	if delta < 0 {
+		delta += 1 << 33
+	}
+	return float64(delta) / 90000.0
+}
+
+// parsePAT reads a Program Association Table section and returns the PID of
+// the first program's PMT, or -1 if one couldn't be found.
+func parsePAT(data []byte) int {
+	section, ok := psiSection(data)
+	if !ok || len(section) < 8 {
+		return -1
+	}
+
+	programData := section[8:]
+	for i := 0; i+4 <= len(programData); i += 4 {
+		programNumber := int(programData[i])<<8 | int(programData[i+1])
+		pid := int(programData[i+2]&0x1f)<<8 | int(programData[i+3])
+		if programNumber != 0 {
+			return pid
+		}
+	}
+	return -1
+}
+
+// parsePMT reads a Program Map Table section and returns the PID of its
+// first video elementary stream, or -1 if one couldn't be found.
+func parsePMT(data []byte) int {
+	section, ok := psiSection(data)
+	if !ok || len(section) < 12 {
+		return -1
+	}
+
+	programInfoLength := int(section[10]&0x0f)<<8 | int(section[11])
+	offset := 12 + programInfoLength
+	for offset+5 <= len(section) {
+		streamType := section[offset]
+		elementaryPID := int(section[offset+1]&0x1f)<<8 | int(section[offset+2])
+		esInfoLength := int(section[offset+3]&0x0f)<<8 | int(section[offset+4])
+		if isVideoStreamType(streamType) {
+			return elementaryPID
+		}
+		offset += 5 + esInfoLength
+	}
+	return -1
+}
+
+// psiSection strips the pointer_field from a PSI packet's payload and
+// returns the section, trimmed to section_length minus the trailing CRC32
+// (section_length counts the CRC32, which we don't need).
+func psiSection(data []byte) ([]byte, bool) {
+	if len(data) < 1 {
+		return nil, false
+	}
+	pointer := int(data[0])
+	if 1+pointer >= len(data) {
+		return nil, false
+	}
+	section := data[1+pointer:]
+	if len(section) < 3 {
+		return nil, false
+	}
+
+	sectionLength := int(section[1]&0x0f)<<8 | int(section[2])
+	end := 3 + sectionLength - 4 // exclude the CRC32
+	if end < 0 || end > len(section) {
+		end = len(section)
+	}
+	return section[:end], true
+}
+
+func isVideoStreamType(streamType byte) bool {
+	switch streamType {
+	case 0x01, 0x02, 0x1b, 0x24: // MPEG-1/2, H.264, HEVC
+		return true
+	}
+	return false
+}
+
+// parsePESPTS extracts the PTS from the start of a PES packet, if present.
+func parsePESPTS(data []byte) (int64, bool) {
+	if len(data) < 14 || data[0] != 0x00 || data[1] != 0x00 || data[2] != 0x01 {
+		return 0, false
+	}
+
+	ptsDTSFlags := data[7] >> 6
+	if ptsDTSFlags&0x2 == 0 {
+		return 0, false
+	}
+
+	pts := (int64(data[9]&0x0e) << 29) |
+		(int64(data[10]) << 22) |
+		(int64(data[11]&0xfe) << 14) |
+		(int64(data[12]) << 7) |
+		(int64(data[13]) >> 1)
+	return pts, true
+}
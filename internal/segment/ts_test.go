@@ -0,0 +1,192 @@
+package segment
+
+import "testing"
+
+// psiPacket wraps a PSI section in the pointer_field + section_length framing
+// psiSection expects, appending a dummy CRC32 so section_length accounts for
+// it the way a real TS packet payload would.
+func psiPacket(section []byte) []byte {
+	payload := append([]byte{0x00}, section...) // pointer_field = 0
+	return append(payload, 0, 0, 0, 0)          // CRC32, unused by psiSection
+}
+
+func patSection(programNumber, pmtPID int) []byte {
+	section := make([]byte, 8+4)
+	section[1] = 0x00 // high nibble of section_length, reserved bits
+	section[2] = byte(len(section) - 3 + 4)
+	section[8] = byte(programNumber >> 8)
+	section[9] = byte(programNumber)
+	section[10] = 0xe0 | byte(pmtPID>>8)
+	section[11] = byte(pmtPID)
+	return section
+}
+
+func TestParsePAT(t *testing.T) {
+	t.Run("finds the first non-zero program's PMT PID", func(t *testing.T) {
+		section := patSection(1, 0x100)
+		if got := parsePAT(psiPacket(section)); got != 0x100 {
+			t.Errorf("parsePAT() = 0x%x, want 0x100", got)
+		}
+	})
+
+	t.Run("skips the network PID entry (program 0)", func(t *testing.T) {
+		section := append(patSection(0, 0x10), patSection(1, 0x200)[8:]...)
+		section[2] = byte(len(section) - 3 + 4)
+		if got := parsePAT(psiPacket(section)); got != 0x200 {
+			t.Errorf("parsePAT() = 0x%x, want 0x200", got)
+		}
+	})
+
+	t.Run("too short", func(t *testing.T) {
+		if got := parsePAT(psiPacket(make([]byte, 4))); got != -1 {
+			t.Errorf("parsePAT() = %d, want -1", got)
+		}
+	})
+}
+
+func pmtSection(streamType byte, elementaryPID int) []byte {
+	const programInfoLength = 0
+	section := make([]byte, 12+5)
+	section[2] = byte(len(section) - 3 + 4)
+	section[10] = byte(programInfoLength >> 8)
+	section[11] = byte(programInfoLength)
+	section[12] = streamType
+	section[13] = 0xe0 | byte(elementaryPID>>8)
+	section[14] = byte(elementaryPID)
+	section[15] = 0 // ES_info_length high
+	section[16] = 0 // ES_info_length low
+	return section
+}
+
+func TestParsePMT(t *testing.T) {
+	t.Run("finds a video elementary stream", func(t *testing.T) {
+		section := pmtSection(0x1b, 0x101) // H.264
+		if got := parsePMT(psiPacket(section)); got != 0x101 {
+			t.Errorf("parsePMT() = 0x%x, want 0x101", got)
+		}
+	})
+
+	t.Run("skips non-video stream types", func(t *testing.T) {
+		audio := pmtSection(0x0f, 0x102) // AAC audio
+		audio[2] = byte(len(audio) - 3 + 4)
+		if got := parsePMT(psiPacket(audio)); got != -1 {
+			t.Errorf("parsePMT() = %d, want -1", got)
+		}
+	})
+
+	t.Run("too short", func(t *testing.T) {
+		if got := parsePMT(psiPacket(make([]byte, 4))); got != -1 {
+			t.Errorf("parsePMT() = %d, want -1", got)
+		}
+	})
+}
+
+func TestIsVideoStreamType(t *testing.T) {
+	tests := []struct {
+		streamType byte
+		want       bool
+	}{
+		{0x01, true},  // MPEG-1 video
+		{0x02, true},  // MPEG-2 video
+		{0x1b, true},  // H.264
+		{0x24, true},  // HEVC
+		{0x0f, false}, // AAC audio
+		{0x81, false}, // AC-3 audio
+	}
+	for _, tc := range tests {
+		if got := isVideoStreamType(tc.streamType); got != tc.want {
+			t.Errorf("isVideoStreamType(0x%02x) = %v, want %v", tc.streamType, got, tc.want)
+		}
+	}
+}
+
+func TestPsiSection(t *testing.T) {
+	t.Run("strips pointer field and trailing CRC", func(t *testing.T) {
+		section := patSection(1, 0x100)
+		got, ok := psiSection(psiPacket(section))
+		if !ok {
+			t.Fatal("psiSection() ok = false, want true")
+		}
+		if len(got) != len(section) {
+			t.Errorf("psiSection() length = %d, want %d", len(got), len(section))
+		}
+	})
+
+	t.Run("pointer field beyond payload", func(t *testing.T) {
+		if _, ok := psiSection([]byte{0x05}); ok {
+			t.Error("expected ok = false for a pointer field past the end of the payload")
+		}
+	})
+
+	t.Run("empty payload", func(t *testing.T) {
+		if _, ok := psiSection(nil); ok {
+			t.Error("expected ok = false for empty payload")
+		}
+	})
+}
+
+func pesHeader(pts int64) []byte {
+	header := make([]byte, 14)
+	header[0], header[1], header[2] = 0x00, 0x00, 0x01
+	header[7] = 0x80 // PTS only
+	header[8] = 5    // PES_header_data_length
+
+	header[9] = 0x21 | byte(pts>>29)&0x0e
+	header[10] = byte(pts >> 22)
+	header[11] = 0x01 | byte(pts>>14)&0xfe
+	header[12] = byte(pts >> 7)
+	header[13] = 0x01 | byte(pts<<1)&0xfe
+	return header
+}
+
+func TestParsePESPTS(t *testing.T) {
+	t.Run("extracts a PTS-only header", func(t *testing.T) {
+		const want = int64(5_400_000) // 60s at 90kHz
+		got, ok := parsePESPTS(pesHeader(want))
+		if !ok {
+			t.Fatal("parsePESPTS() ok = false, want true")
+		}
+		if got != want {
+			t.Errorf("parsePESPTS() = %d, want %d", got, want)
+		}
+	})
+
+	t.Run("no PTS/DTS flags set", func(t *testing.T) {
+		header := pesHeader(0)
+		header[7] = 0x00
+		if _, ok := parsePESPTS(header); ok {
+			t.Error("expected ok = false when PTS_DTS_flags indicates no PTS")
+		}
+	})
+
+	t.Run("missing start code", func(t *testing.T) {
+		header := pesHeader(0)
+		header[2] = 0x02
+		if _, ok := parsePESPTS(header); ok {
+			t.Error("expected ok = false for a bad start code")
+		}
+	})
+
+	t.Run("too short", func(t *testing.T) {
+		if _, ok := parsePESPTS(make([]byte, 10)); ok {
+			t.Error("expected ok = false for a header shorter than 14 bytes")
+		}
+	})
+}
+
+func TestPtsDelta(t *testing.T) {
+	t.Run("forward", func(t *testing.T) {
+		got := ptsDelta(180000, 90000) // 1s at 90kHz
+		if want := 1.0; got != want {
+			t.Errorf("ptsDelta() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("wraps around the 33-bit clock", func(t *testing.T) {
+		const maxPTS = int64(1) << 33
+		got := ptsDelta(90000, maxPTS-90000) // start near wraparound, pts just after it
+		if want := 2.0; got != want {
+			t.Errorf("ptsDelta() = %v, want %v", got, want)
+		}
+	})
+}
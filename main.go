@@ -11,9 +11,14 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/chadnickbok/lhls_test/internal/playlist"
+	"github.com/chadnickbok/lhls_test/internal/segment"
 	"github.com/fujiwara/shapeio"
 	"github.com/grafov/m3u8"
 	"github.com/rs/cors"
@@ -22,25 +27,145 @@ import (
 // How much time to project into the future
 const futureTime = 5.0
 
+// liveWinSize/liveCapacity size the rolling m3u8.MediaPlaylist that is
+// actually handed to clients: winsize segments are kept visible at once
+// (once winsize is reached, Slide evicts the oldest before appending), and
+// capacity bounds how many segments the playlist can hold.
+const liveWinSize = 10
+const liveCapacity = 20
+
+// partSuffix recognizes a CMAF-part request, e.g. "segment3.ts.part2",
+// synthesized by splitting a segment file into equal byte ranges.
+var partSuffix = regexp.MustCompile(`\.part(\d+)$`)
+
+// llPart describes one CMAF part synthesized from a byte range of a
+// segment's underlying file.
+type llPart struct {
+	index    int
+	uri      string
+	duration float64
+	offset   int64
+	length   int64
+}
+
 type FakeLHLSManifestHandler struct {
 	startTime time.Time
 	duration  float64
-	playlist  *m3u8.MediaPlaylist
-	baseDir   string
+	// source holds the segments read from the input playlist, in order.
+	// It never changes; it's looped over to build the rolling live window.
+	source  []*m3u8.MediaSegment
+	baseDir string
+	// mountPrefix is the URL path this handler is mounted under, e.g.
+	// "/lhls/" or "/lhls/1200000/", and is stripped off to recover the
+	// segment URI used to look the segment up in source.
+	mountPrefix string
+	// discontinuityEvery marks an EXT-X-DISCONTINUITY every N times the
+	// source loops back to its start; 0 disables discontinuity marking.
+	discontinuityEvery int
+	// llHLS and partTarget enable Low-Latency HLS mode: EXT-X-PART/
+	// EXT-X-PRELOAD-HINT are added to the manifest, and parts are synthesized
+	// by splitting each segment's file into partTarget-second byte ranges.
+	llHLS      bool
+	partTarget float64
+
+	mu        sync.Mutex
+	live      *m3u8.MediaPlaylist // rolling window actually served to clients
+	nextIndex int                 // index into source (mod len(source)) of the next segment to slide in
+	appended  float64             // total duration of segments appended to live so far
+	loopCount int                 // number of times source has looped back to its start
+}
+
+// newVariantHandler loads a single media playlist from disk and builds the
+// handler that will serve it (both the manifest and its segments).
+func newVariantHandler(playlistPath string) (*FakeLHLSManifestHandler, error) {
+	f, err := os.Open(playlistPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", playlistPath, err)
+	}
+	defer f.Close()
+
+	p, listType, err := m3u8.DecodeFrom(bufio.NewReader(f), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", playlistPath, err)
+	}
+
+	if listType != m3u8.MEDIA {
+		return nil, fmt.Errorf("%s is not a media playlist", playlistPath)
+	}
+
+	handler, err := newVariantHandlerFromPlaylist(p.(*m3u8.MediaPlaylist), filepath.Dir(playlistPath))
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Loaded variant", playlistPath, "duration:", handler.duration)
+	return handler, nil
+}
+
+// newVariantHandlerFromPlaylist builds a handler around an already-decoded
+// media playlist, whether it came from disk (newVariantHandler) or was
+// built in memory by internal/segment + internal/playlist.
+func newVariantHandlerFromPlaylist(mediapl *m3u8.MediaPlaylist, baseDir string) (*FakeLHLSManifestHandler, error) {
+	source := make([]*m3u8.MediaSegment, 0, len(mediapl.Segments))
+	duration := 0.0
+	for _, segment := range mediapl.Segments {
+		if segment != nil {
+			source = append(source, segment)
+			duration += segment.Duration
+		}
+	}
+
+	live, err := m3u8.NewMediaPlaylist(liveWinSize, liveCapacity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create live playlist: %w", err)
+	}
+
+	return &FakeLHLSManifestHandler{
+		duration:  duration,
+		startTime: time.Now(),
+		source:    source,
+		baseDir:   baseDir,
+		live:      live,
+	}, nil
+}
+
+// setMountPrefix records the URL path this handler was mounted under, so
+// ServeSegment can recover the segment URI from the request path.
+func (l *FakeLHLSManifestHandler) setMountPrefix(prefix string) *FakeLHLSManifestHandler {
+	l.mountPrefix = prefix
+	return l
 }
 
 func (l *FakeLHLSManifestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if strings.HasSuffix(req.URL.EscapedPath(), "manifest.m3u8") {
+	switch {
+	case req.Method == http.MethodPost && strings.Contains(req.URL.EscapedPath(), "/ingest/"):
+		l.ServeIngest(w, req)
+	case strings.HasSuffix(req.URL.EscapedPath(), "manifest.m3u8"):
 		l.ServeManifest(w, req)
-	} else if strings.HasPrefix(req.URL.EscapedPath(), "/lhls/") {
+	case l.llHLS && partSuffix.MatchString(req.URL.EscapedPath()):
+		l.ServePart(w, req)
+	default:
 		l.ServeSegment(w, req)
-	} else {
-		http.NotFound(w, req)
 	}
 }
 
+// findSegment looks up a segment by its URI (as it appears in source) and
+// returns its index and the wall-clock offset, relative to the start of a
+// single pass over source, at which it begins.
+func (l *FakeLHLSManifestHandler) findSegment(uri string) (int, *m3u8.MediaSegment, float64) {
+	startTime := 0.0
+	for i, segment := range l.source {
+		if strings.EqualFold(segment.URI, uri) {
+			return i, segment, startTime
+		}
+		startTime += segment.Duration
+	}
+	return -1, nil, 0
+}
+
+// ServeSegment serves a single segment file, named by the request path with
+// mountPrefix stripped off, relative to this variant's baseDir.
 func (l *FakeLHLSManifestHandler) ServeSegment(w http.ResponseWriter, req *http.Request) {
-	curSegmentURL := req.URL.EscapedPath()[len("/lhls/"):]
+	curSegmentURL := strings.TrimPrefix(req.URL.EscapedPath(), l.mountPrefix)
 	curFilePath := path.Join(l.baseDir, curSegmentURL)
 
 	file, err := os.Open(curFilePath) // For read access.
@@ -58,22 +183,23 @@ func (l *FakeLHLSManifestHandler) ServeSegment(w http.ResponseWriter, req *http.
 		return
 	}
 
-	var segment *m3u8.MediaSegment
-	segmentStartTime := 0.0
-	for _, curSegment := range l.playlist.Segments {
-		if strings.EqualFold(curSegment.URI, curSegmentURL) {
-			segment = curSegment
-			break
-		}
-		segmentStartTime += curSegment.Duration
-	}
-
+	_, segment, segmentStartTime := l.findSegment(curSegmentURL)
 	if segment == nil {
+		// Not part of the looping source playlist; it may have been pushed
+		// live via the ingest endpoint, in which case the encoder already
+		// paced it in real time and it can just be served as-is.
+		if duration, ok := l.liveSegmentDuration(curSegmentURL); ok {
+			fmt.Printf("Serving ingested segment %s (duration %f)\n", curSegmentURL, duration)
+			w.Header().Set("Content-Type", "video/MP2T")
+			w.WriteHeader(http.StatusOK)
+			io.Copy(w, file)
+			return
+		}
 		http.NotFound(w, req)
 		return
 	}
 
-	curStreamTime := time.Since(l.startTime).Seconds()
+	curStreamTime := math.Mod(time.Since(l.startTime).Seconds(), l.duration)
 	if segmentStartTime > curStreamTime {
 		sleepDuration := segmentStartTime - curStreamTime
 		fmt.Println("Segment is in the future, waiting for", sleepDuration)
@@ -97,51 +223,587 @@ func (l *FakeLHLSManifestHandler) ServeSegment(w http.ResponseWriter, req *http.
 	return
 }
 
+// liveSegmentDuration looks up a segment's duration in the live playlist
+// itself, rather than in source, for segments pushed in via ServeIngest.
+func (l *FakeLHLSManifestHandler) liveSegmentDuration(uri string) (float64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, seg := range l.live.Segments {
+		if seg != nil && strings.EqualFold(seg.URI, uri) {
+			return seg.Duration, true
+		}
+	}
+	return 0, false
+}
+
+// defaultIngestSegmentDuration is used for an ingested segment when the
+// encoder doesn't tell us its duration via the "duration" query parameter.
+const defaultIngestSegmentDuration = 6.0
+
+// ServeIngest lets an external encoder push a new segment in real time via
+// POST /lhls/ingest/<segment>, turning this handler from a file replayer
+// into a live pass-through relay: the segment is written to baseDir and
+// immediately appended (or, once the window is full, slid) onto the live
+// playlist, so the very next ServeManifest call reflects it.
+func (l *FakeLHLSManifestHandler) ServeIngest(w http.ResponseWriter, req *http.Request) {
+	curSegmentURL := strings.TrimPrefix(req.URL.EscapedPath(), l.mountPrefix+"ingest/")
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.Println("Failed to read ingested segment body:", err)
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	if err := os.WriteFile(path.Join(l.baseDir, curSegmentURL), body, 0o644); err != nil {
+		log.Println("Failed to write ingested segment:", err)
+		http.Error(w, "failed to store segment", http.StatusInternalServerError)
+		return
+	}
+
+	duration := defaultIngestSegmentDuration
+	if durationParam := req.URL.Query().Get("duration"); durationParam != "" {
+		if d, err := strconv.ParseFloat(durationParam, 64); err == nil {
+			duration = d
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.live.Count() >= l.live.WinSize() {
+		l.live.Slide(curSegmentURL, duration, "")
+	} else if err := l.live.Append(curSegmentURL, duration, ""); err != nil {
+		log.Println("Failed to append ingested segment:", err)
+		http.Error(w, "failed to append segment", http.StatusInternalServerError)
+		return
+	}
+	if err := l.live.SetProgramDateTime(time.Now()); err != nil {
+		log.Println("Failed to set program date time:", err)
+	}
+
+	fmt.Println("Ingested segment", curSegmentURL, "duration", duration)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// partsForSegment synthesizes CMAF parts for a segment by splitting its
+// underlying file into partTarget-second, equal-byte-length ranges. The file
+// isn't demuxed for this, so parts don't align to keyframes; they're
+// just enough to exercise a player's LL-HLS part-fetching path.
+func (l *FakeLHLSManifestHandler) partsForSegment(segIndex int) ([]llPart, error) {
+	segment := l.source[segIndex]
+	filePath := path.Join(l.baseDir, segment.URI)
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	numParts := int(math.Ceil(segment.Duration / l.partTarget))
+	if numParts < 1 {
+		numParts = 1
+	}
+
+	size := info.Size()
+	partSize := size / int64(numParts)
+
+	parts := make([]llPart, numParts)
+	var offset int64
+	for i := 0; i < numParts; i++ {
+		length := partSize
+		duration := l.partTarget
+		if i == numParts-1 {
+			length = size - offset
+			duration = segment.Duration - float64(i)*l.partTarget
+		}
+
+		parts[i] = llPart{
+			index:    i,
+			uri:      segment.URI + ".part" + strconv.Itoa(i),
+			duration: duration,
+			offset:   offset,
+			length:   length,
+		}
+		offset += length
+	}
+
+	return parts, nil
+}
+
+// ServePart streams a single synthesized CMAF part using chunked transfer,
+// flushing after every read so a client sees it arrive incrementally instead
+// of all at once, the way a real LL-HLS origin would push out a part as the
+// encoder produces it.
+func (l *FakeLHLSManifestHandler) ServePart(w http.ResponseWriter, req *http.Request) {
+	curURL := strings.TrimPrefix(req.URL.EscapedPath(), l.mountPrefix)
+	loc := partSuffix.FindStringSubmatchIndex(curURL)
+	if loc == nil {
+		http.NotFound(w, req)
+		return
+	}
+	segmentURL := curURL[:loc[0]]
+	partIndex, err := strconv.Atoi(curURL[loc[2]:loc[3]])
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	segIndex, segment, segmentStartTime := l.findSegment(segmentURL)
+	if segment == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	parts, err := l.partsForSegment(segIndex)
+	if err != nil || partIndex >= len(parts) {
+		log.Println("Failed to resolve part:", err)
+		http.NotFound(w, req)
+		return
+	}
+	requestedPart := parts[partIndex]
+
+	file, err := os.Open(path.Join(l.baseDir, segmentURL))
+	if err != nil {
+		log.Println("Failed to open file:", err)
+		http.NotFound(w, req)
+		return
+	}
+	defer file.Close()
+
+	partStartTime := segmentStartTime + float64(requestedPart.index)*l.partTarget
+	curStreamTime := math.Mod(time.Since(l.startTime).Seconds(), l.duration)
+	if partStartTime > curStreamTime {
+		sleepDuration := partStartTime - curStreamTime
+		fmt.Println("Part is in the future, waiting for", sleepDuration)
+		time.Sleep(time.Duration(sleepDuration * float64(time.Second)))
+	}
+
+	if _, err := file.Seek(requestedPart.offset, io.SeekStart); err != nil {
+		log.Println("Failed to seek part:", err)
+		http.NotFound(w, req)
+		return
+	}
+
+	contentType := "video/MP2T"
+	if strings.EqualFold(filepath.Ext(segmentURL), ".mp4") {
+		contentType = "video/iso.segment"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Printf("Streaming part %s (part %d, %d bytes)\n", segmentURL, requestedPart.index, requestedPart.length)
+
+	flusher, canFlush := w.(http.Flusher)
+	buf := make([]byte, 4096)
+	remaining := requestedPart.length
+	for remaining > 0 {
+		n := int64(len(buf))
+		if remaining < n {
+			n = remaining
+		}
+
+		read, err := file.Read(buf[:n])
+		if read > 0 {
+			w.Write(buf[:read])
+			if canFlush {
+				flusher.Flush()
+			}
+			remaining -= int64(read)
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Println("Failed reading part:", err)
+			}
+			break
+		}
+	}
+}
+
+// advanceLive slides segments from source into the live playlist until it
+// covers curStreamTime+futureTime, stamping each newly-visible segment with
+// an EXT-X-PROGRAM-DATE-TIME and marking an EXT-X-DISCONTINUITY every
+// discontinuityEvery-th time the source loops back to its start (so a player
+// isn't confused by the decoder state implicitly resetting); no
+// discontinuity is marked when discontinuityEvery is 0.
+func (l *FakeLHLSManifestHandler) advanceLive(curStreamTime float64) {
+	n := len(l.source)
+	if n == 0 {
+		return
+	}
+
+	for l.appended < curStreamTime+futureTime {
+		idx := l.nextIndex % n
+		loop := l.nextIndex / n
+		segment := l.source[idx]
+
+		markDiscontinuity := false
+		if idx == 0 && loop > l.loopCount {
+			l.loopCount = loop
+			if l.discontinuityEvery > 0 && l.loopCount%l.discontinuityEvery == 0 {
+				markDiscontinuity = true
+			}
+		}
+
+		if l.live.Count() >= l.live.WinSize() {
+			l.live.Slide(segment.URI, segment.Duration, segment.Title)
+		} else if err := l.live.Append(segment.URI, segment.Duration, segment.Title); err != nil {
+			fmt.Println("Failed to append segment:", err)
+			return
+		}
+
+		if markDiscontinuity {
+			// SetDiscontinuity flags p.last(), so it must run after the
+			// Append/Slide above to land on the segment that actually
+			// restarts the source, not the last one of the prior loop.
+			if err := l.live.SetDiscontinuity(); err != nil {
+				fmt.Println("Failed to set discontinuity:", err)
+			}
+		}
+
+		offset := time.Duration((l.appended - curStreamTime) * float64(time.Second))
+		if err := l.live.SetProgramDateTime(time.Now().Add(offset)); err != nil {
+			fmt.Println("Failed to set program date time:", err)
+		}
+
+		l.appended += segment.Duration
+		l.nextIndex++
+	}
+}
+
 func (l *FakeLHLSManifestHandler) ServeManifest(w http.ResponseWriter, req *http.Request) {
+	if l.llHLS {
+		l.blockForReload(req)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// curStreamTime grows monotonically for the life of the handler; looping
+	// back to the start of source is handled inside advanceLive, not by
+	// resetting startTime, so it stays consistent with l.appended.
 	curStreamTime := time.Since(l.startTime).Seconds()
 	fmt.Println("Generating playlist, curStreamTime: ", curStreamTime)
 
-	curPlaylist, err := m3u8.NewMediaPlaylist(10, 10)
+	l.advanceLive(curStreamTime)
+
+	body := l.live.Encode().Bytes()
+	if l.llHLS {
+		body = l.injectLLHLSTags(body, curStreamTime)
+	}
+
+	w.Header().Set("Content-Type", "application/x-mpegURL")
+	w.WriteHeader(200)
+	w.Write(body)
+}
+
+// blockForReload implements the LL-HLS "_HLS_msn"/"_HLS_part" blocking
+// playlist reload: if the client asked for a media sequence/part that isn't
+// visible yet, hold the response until it becomes available (or give up
+// after a while, rather than hang forever).
+func (l *FakeLHLSManifestHandler) blockForReload(req *http.Request) {
+	query := req.URL.Query()
+	msnParam := query.Get("_HLS_msn")
+	if msnParam == "" {
+		return
+	}
+
+	msn, err := strconv.ParseUint(msnParam, 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("oops"))
 		return
 	}
 
-	// XXX: Reset stream if duration has passed
-	if l.duration < curStreamTime {
-		curStreamTime = 0
-		l.startTime = time.Now()
+	part := -1
+	if partParam := query.Get("_HLS_part"); partParam != "" {
+		if p, err := strconv.Atoi(partParam); err == nil {
+			part = p
+		}
 	}
 
-	curDuration := 0.0
-	sequence := -1
-	for i, segment := range l.playlist.Segments {
-		curDuration += segment.Duration
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		l.advanceLive(time.Since(l.startTime).Seconds())
+		ready := l.reloadReady(msn, part)
+		l.mu.Unlock()
 
-		if (curDuration + (3 * l.playlist.TargetDuration)) > curStreamTime {
-			if sequence == -1 {
-				sequence = i
-			}
-			curPlaylist.AppendSegment(segment)
+		if ready {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// reloadReady reports whether the segment numbered msn (and, if part >= 0,
+// that specific part of it) has been produced yet. Sequence numbers here are
+// 0-based counts of segments appended since startup, matching l.nextIndex.
+func (l *FakeLHLSManifestHandler) reloadReady(msn uint64, part int) bool {
+	n := len(l.source)
+	if n == 0 {
+		return l.ingestReloadReady(msn)
+	}
+	if l.nextIndex == 0 {
+		return false
+	}
+
+	lastSeq := uint64(l.nextIndex - 1)
+	if lastSeq < msn {
+		return false
+	}
+	if part < 0 || lastSeq > msn {
+		return true
+	}
+
+	segIndex := int(msn) % n
+	parts, err := l.partsForSegment(segIndex)
+	if err != nil || part >= len(parts) {
+		return true
+	}
+
+	segmentStartTime := l.appended - l.source[segIndex].Duration
+	partStartTime := segmentStartTime + float64(part)*l.partTarget
+	return partStartTime <= time.Since(l.startTime).Seconds()
+}
+
+// ingestReloadReady is reloadReady's path for handlers with no fixed source
+// (the -ingest relay), where segments arrive one at a time via ServeIngest
+// instead of being paced out of l.source, so l.nextIndex never advances.
+// Pushed segments aren't split into parts, so part-level readiness isn't
+// modeled: once msn itself has been ingested, every part of it is
+// considered ready, which is enough to stop a blocking reload from stalling
+// for its full timeout.
+func (l *FakeLHLSManifestHandler) ingestReloadReady(msn uint64) bool {
+	for _, seg := range l.live.Segments {
+		if seg != nil && uint64(seg.SeqId) >= msn {
+			return true
 		}
+	}
+	return false
+}
 
-		if curDuration > (curStreamTime + futureTime) {
+// injectLLHLSTags adds the LL-HLS tags the grafov/m3u8 encoder doesn't know
+// about: EXT-X-PART-INF/EXT-X-SERVER-CONTROL up top, EXT-X-PART entries for
+// the parts of the most recently appended segment that are "available", and
+// an EXT-X-PRELOAD-HINT for the part a client should block-reload for next.
+func (l *FakeLHLSManifestHandler) injectLLHLSTags(body []byte, curStreamTime float64) []byte {
+	n := len(l.source)
+	if n == 0 || l.nextIndex == 0 {
+		return body
+	}
+
+	lines := strings.Split(string(body), "\n")
+	out := make([]string, 0, len(lines)+8)
+	for _, line := range lines {
+		out = append(out, line)
+		if strings.HasPrefix(line, "#EXT-X-TARGETDURATION") {
+			out = append(out, fmt.Sprintf("#EXT-X-PART-INF:PART-TARGET=%.3f", l.partTarget))
+			out = append(out, fmt.Sprintf("#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f", 2*l.partTarget))
+		}
+	}
+
+	lastIndex := (l.nextIndex - 1) % n
+	parts, err := l.partsForSegment(lastIndex)
+	if err != nil {
+		return []byte(strings.Join(out, "\n"))
+	}
+
+	segmentStartTime := l.appended - l.source[lastIndex].Duration
+	nextPartIndex := 0
+	for _, part := range parts {
+		partStartTime := segmentStartTime + float64(part.index)*l.partTarget
+		if partStartTime > curStreamTime {
 			break
 		}
+
+		tag := fmt.Sprintf("#EXT-X-PART:DURATION=%.3f,URI=\"%s\"", part.duration, part.uri)
+		if part.index == 0 {
+			tag += ",INDEPENDENT=YES"
+		}
+		out = append(out, tag)
+		nextPartIndex = part.index + 1
 	}
 
-	w.Header().Set("Content-Type", "application/x-mpegURL")
-	w.WriteHeader(200)
-	curPlaylist.SeqNo = uint64(sequence)
-	w.Write(curPlaylist.Encode().Bytes())
+	var preloadURI string
+	if nextPartIndex < len(parts) {
+		preloadURI = parts[nextPartIndex].uri
+	} else if nextParts, err := l.partsForSegment(l.nextIndex % n); err == nil && len(nextParts) > 0 {
+		preloadURI = nextParts[0].uri
+	}
+	if preloadURI != "" {
+		out = append(out, fmt.Sprintf("#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"", preloadURI))
+	}
+
+	return []byte(strings.Join(out, "\n"))
+}
+
+// variantName derives the path segment a variant is mounted under, e.g.
+// "/lhls/<variantName>/manifest.m3u8", from its bandwidth so multiple
+// renditions never collide.
+func variantName(v *m3u8.Variant) string {
+	return strconv.FormatUint(uint64(v.Bandwidth), 10)
+}
+
+// serveMasterPlaylist handles requests for the top-level master.m3u8, which
+// just lists the variants and their mount points; the real work happens in
+// each variant's own FakeLHLSManifestHandler.
+func serveMasterPlaylist(master *m3u8.MasterPlaylist) http.HandlerFunc {
+	body := master.Encode().Bytes()
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/x-mpegURL")
+		w.WriteHeader(200)
+		w.Write(body)
+	}
+}
+
+// segmentInputHandler demuxes an arbitrary MPEG-TS or fMP4 file into
+// keyframe-aligned segments under a temp directory via internal/segment,
+// builds an in-memory playlist for them via internal/playlist, and wraps
+// that up in a handler exactly as if it had been loaded from an input.m3u8.
+func segmentInputHandler(inputPath string, targetDuration float64) (*FakeLHLSManifestHandler, error) {
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", inputPath, err)
+	}
+	defer f.Close()
+
+	outDir, err := os.MkdirTemp("", "lhls_test-segments-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment dir: %w", err)
+	}
+	fmt.Println("Segmenting", inputPath, "into", outDir)
+
+	var segments []segment.Segment
+	switch strings.ToLower(filepath.Ext(inputPath)) {
+	case ".mp4", ".m4s", ".m4v":
+		segments, err = segment.SegmentMP4(f, outDir)
+	default:
+		segments, err = segment.SegmentTS(f, targetDuration, outDir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to segment %s: %w", inputPath, err)
+	}
+
+	mediapl, err := playlist.Build(segments, targetDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	handler, err := newVariantHandlerFromPlaylist(mediapl, outDir)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Segmented", len(segments), "segments, duration:", handler.duration)
+	return handler, nil
+}
+
+// loadMaster decodes a master playlist and builds a handler per variant,
+// rewriting the master's variant URIs to point at this server's own
+// per-variant mount points so ABR players resolve them the same way they'd
+// resolve a real LHLS origin (cf. mediamtx's internal/hls/client.go, which
+// decodes a MasterPlaylist and then follows one of its Variants.URI).
+func loadMaster(masterPath string, prefix string) (*m3u8.MasterPlaylist, map[string]*FakeLHLSManifestHandler, error) {
+	f, err := os.Open(masterPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", masterPath, err)
+	}
+	defer f.Close()
+
+	p, listType, err := m3u8.DecodeFrom(bufio.NewReader(f), true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode %s: %w", masterPath, err)
+	}
+
+	if listType != m3u8.MASTER {
+		return nil, nil, fmt.Errorf("%s is not a master playlist", masterPath)
+	}
+
+	master := p.(*m3u8.MasterPlaylist)
+	masterDir := filepath.Dir(masterPath)
+
+	handlers := make(map[string]*FakeLHLSManifestHandler, len(master.Variants))
+	for _, variant := range master.Variants {
+		variantPath := variant.URI
+		if !path.IsAbs(variantPath) {
+			variantPath = filepath.Join(masterDir, variantPath)
+		}
+
+		handler, err := newVariantHandler(variantPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		name := variantName(variant)
+		handlers[name] = handler
+		variant.URI = prefix + name + "/manifest.m3u8"
+	}
+
+	return master, handlers, nil
 }
 
 func main() {
+	discontinuityEvery := flag.Int("discontinuity-every", 0, "insert an EXT-X-DISCONTINUITY every N times the source loops back to its start (0 disables)")
+	llHLS := flag.Bool("ll-hls", false, "enable Low-Latency HLS mode (EXT-X-PART/EXT-X-PRELOAD-HINT, CMAF-style parts)")
+	partDuration := flag.Float64("part-duration", 1.0, "target duration in seconds for each LL-HLS part")
+	segmentInput := flag.String("segment", "", "segment an arbitrary MPEG-TS or fMP4 file into an LHLS endpoint, instead of taking a pre-segmented input.m3u8")
+	segmentTargetDuration := flag.Float64("segment-target-duration", 6.0, "target segment duration in seconds when using -segment")
+	ingest := flag.Bool("ingest", false, "start with an empty playlist, fed entirely by POSTs to /lhls/ingest/<segment>.ts, for use as a live relay driven by an external encoder")
 	flag.Parse()
 	args := flag.Args()
+
+	mux := http.NewServeMux()
+
+	if *ingest {
+		outDir, err := os.MkdirTemp("", "lhls_test-ingest-")
+		if err != nil {
+			fmt.Println("Failed to create ingest dir:", err)
+			return
+		}
+		fmt.Println("Ingest mode, storing pushed segments in", outDir)
+
+		handler, err := newVariantHandlerFromPlaylist(&m3u8.MediaPlaylist{}, outDir)
+		if err != nil {
+			fmt.Println("Failed to create ingest handler:", err)
+			return
+		}
+		handler.setMountPrefix("/lhls/")
+		handler.discontinuityEvery = *discontinuityEvery
+		handler.llHLS = *llHLS
+		handler.partTarget = *partDuration
+
+		mux.Handle("/lhls/manifest.m3u8", handler)
+		mux.Handle("/lhls/", handler)
+
+		corsHandler := cors.Default().Handler(mux)
+		log.Fatal(http.ListenAndServe(":8080", corsHandler))
+		return
+	}
+
+	if *segmentInput != "" {
+		handler, err := segmentInputHandler(*segmentInput, *segmentTargetDuration)
+		if err != nil {
+			fmt.Println("Failed to segment input:", err)
+			return
+		}
+		handler.setMountPrefix("/lhls/")
+		handler.discontinuityEvery = *discontinuityEvery
+		handler.llHLS = *llHLS
+		handler.partTarget = *partDuration
+		fmt.Println("Duration: ", handler.duration)
+
+		mux.Handle("/live/manifest.m3u8", handler)
+		mux.Handle("/live/", http.StripPrefix("/live/", http.FileServer(http.Dir(handler.baseDir))))
+		mux.Handle("/lhls/manifest.m3u8", handler)
+		mux.Handle("/lhls/", handler)
+
+		corsHandler := cors.Default().Handler(mux)
+		log.Fatal(http.ListenAndServe(":8080", corsHandler))
+		return
+	}
+
 	if len(args) != 1 {
 		fmt.Println("Usage: lhls_faker input.m3u8")
+		return
 	}
 
 	f, err := os.Open(args[0])
@@ -150,50 +812,56 @@ func main() {
 		return
 	}
 
-	p, listType, err := m3u8.DecodeFrom(bufio.NewReader(f), true)
+	_, listType, err := m3u8.DecodeFrom(bufio.NewReader(f), true)
+	f.Close()
 	if err != nil {
 		fmt.Println("Failed to decode m3u8", err)
 		return
 	}
 
-	if listType != m3u8.MEDIA {
-		fmt.Println("Script only supports media playlists")
-		return
-	}
-
-	segmentDir := filepath.Dir(args[0])
-	fmt.Println("Segmentdir: ", segmentDir)
-
-	mediapl := p.(*m3u8.MediaPlaylist)
-	duration := 0.0
-	for _, segment := range mediapl.Segments {
-		if segment != nil {
-			segmentLocation := segment.URI
-			if !path.IsAbs(segmentLocation) {
-				segmentLocation = path.Join(segmentDir, segment.URI)
-			}
-			duration += segment.Duration
+	switch listType {
+	case m3u8.MASTER:
+		master, variants, err := loadMaster(args[0], "/lhls/")
+		if err != nil {
+			fmt.Println("Failed to load master playlist:", err)
+			return
 		}
-	}
-	fmt.Println("Duration: ", duration)
 
-	mux := http.NewServeMux()
+		for name, handler := range variants {
+			handler.setMountPrefix("/lhls/" + name + "/")
+			handler.discontinuityEvery = *discontinuityEvery
+			handler.llHLS = *llHLS
+			handler.partTarget = *partDuration
+			mux.Handle("/lhls/"+name+"/manifest.m3u8", handler)
+			mux.Handle("/lhls/"+name+"/", handler)
+		}
+		mux.Handle("/lhls/master.m3u8", serveMasterPlaylist(master))
 
-	lhlsHandler := &FakeLHLSManifestHandler{
-		duration:  duration,
-		startTime: time.Now(),
-		playlist:  mediapl,
-		baseDir:   path.Dir(args[0]),
+	case m3u8.MEDIA:
+		handler, err := newVariantHandler(args[0])
+		if err != nil {
+			fmt.Println("Failed to load playlist:", err)
+			return
+		}
+		handler.setMountPrefix("/lhls/")
+		handler.discontinuityEvery = *discontinuityEvery
+		handler.llHLS = *llHLS
+		handler.partTarget = *partDuration
+		fmt.Println("Duration: ", handler.duration)
+
+		// XXX: For testing, "/live/manifest" serves up a manifest that will work 'normally'
+		mux.Handle("/live/manifest.m3u8", handler)
+		mux.Handle("/live/", http.StripPrefix("/live/", http.FileServer(http.Dir(path.Dir(args[0])))))
+
+		// XXX: "/lhls/manifest" servces up a manifest where segments will behave like LHLS segments
+		mux.Handle("/lhls/manifest.m3u8", handler)
+		mux.Handle("/lhls/", handler)
+
+	default:
+		fmt.Println("Script only supports media or master playlists")
+		return
 	}
 
-	// XXX: For testing, "/live/manifest" serves up a manifest that will work 'normally'
-	mux.Handle("/live/manifest.m3u8", lhlsHandler)
-	mux.Handle("/live/", http.StripPrefix("/live/", http.FileServer(http.Dir(path.Dir(args[0])))))
-
-	// XXX: "/lhls/manifest" servces up a manifest where segments will behave like LHLS segments
-	mux.Handle("/lhls/manifest.m3u8", lhlsHandler)
-	mux.Handle("/lhls/", lhlsHandler)
-
 	corsHandler := cors.Default().Handler(mux)
 	log.Fatal(http.ListenAndServe(":8080", corsHandler))
 }